@@ -5,10 +5,14 @@ import (
 	"fmt"
 	"net/url"
 	"strconv"
-	"strings"
 	"time"
 )
 
+// defaultAsyncMaxFlows is the MaxFLows value assumed when a caller leaves MaxFLows unset on an
+// async query. It matches the PCE's documented cap for a single traffic_analysis_queries job and
+// is used to decide whether a time window needs to be bisected and re-issued.
+const defaultAsyncMaxFlows = 499999
+
 // TrafficAnalysisRequest represents the payload object for the traffic analysis POST request
 type TrafficAnalysisRequest struct {
 	Sources          Sources          `json:"sources"`
@@ -115,12 +119,60 @@ type TimestampRange struct {
 	LastDetected  string `json:"last_detected"`
 }
 
+// TrafficSelector is a typed set of sources or destinations to include or exclude from a traffic
+// analysis query. Exactly the fields that are populated are sent to the explorer API - a selector
+// can mix labels, workloads, IPs, and CIDRs in the same Include/Exclude list.
+type TrafficSelector struct {
+	Labels    []Label
+	Workloads []Workload
+	IPs       []string
+	CIDRs     []string
+}
+
+// includes converts a TrafficSelector into the Include objects used by the explorer API's
+// sources/destinations include list.
+func (ts TrafficSelector) includes() []Include {
+	includes := make([]Include, 0, len(ts.Labels)+len(ts.Workloads)+len(ts.IPs)+len(ts.CIDRs))
+	for _, label := range ts.Labels {
+		includes = append(includes, Include{Label: &Label{Href: label.Href}})
+	}
+	for _, workload := range ts.Workloads {
+		includes = append(includes, Include{Workload: &Workload{Href: workload.Href}})
+	}
+	for _, ip := range ts.IPs {
+		includes = append(includes, Include{IPAddress: &IPAddress{Value: ip}})
+	}
+	for _, cidr := range ts.CIDRs {
+		includes = append(includes, Include{IPAddress: &IPAddress{Value: cidr}})
+	}
+	return includes
+}
+
+// excludes converts a TrafficSelector into the Exclude objects used by the explorer API's
+// sources/destinations exclude list.
+func (ts TrafficSelector) excludes() []Exclude {
+	excludes := make([]Exclude, 0, len(ts.Labels)+len(ts.Workloads)+len(ts.IPs)+len(ts.CIDRs))
+	for _, label := range ts.Labels {
+		excludes = append(excludes, Exclude{Label: &Label{Href: label.Href}})
+	}
+	for _, workload := range ts.Workloads {
+		excludes = append(excludes, Exclude{Workload: &Workload{Href: workload.Href}})
+	}
+	for _, ip := range ts.IPs {
+		excludes = append(excludes, Exclude{IPAddress: &IPAddress{Value: ip}})
+	}
+	for _, cidr := range ts.CIDRs {
+		excludes = append(excludes, Exclude{IPAddress: &IPAddress{Value: cidr}})
+	}
+	return excludes
+}
+
 // TrafficQuery is the struct to be passed to the GetTrafficAnalysis function
 type TrafficQuery struct {
-	SourcesInclude        []string
-	SourcesExclude        []string
-	DestinationsInclude   []string
-	DestinationsExclude   []string
+	SourcesInclude        TrafficSelector
+	SourcesExclude        TrafficSelector
+	DestinationsInclude   TrafficSelector
+	DestinationsExclude   TrafficSelector
 	PortProtoInclude      [][2]int
 	PortProtoExclude      [][2]int
 	PortRangeInclude      [][2]int
@@ -139,78 +191,46 @@ type TrafficQuery struct {
 func GetTrafficAnalysis(pce PCE, query TrafficQuery) ([]TrafficAnalysis, APIResponse, error) {
 	var api APIResponse
 
-	// Initialize arrays using "make" so JSON is marshaled with empty arrays and not null values to meet Illumio API spec
-	sourceInc := make([]Include, 0)
-	destInc := make([]Include, 0)
-
-	sourceExcl := make([]Exclude, 0)
-	destExcl := make([]Exclude, 0)
-
-	// Process source include, destination include, source exclude, and destination exclude
-	queryLists := [][]string{query.SourcesInclude, query.DestinationsInclude, query.SourcesExclude, query.DestinationsExclude}
-
-	// Start counter
-	i := 0
-
-	// For each list there are 4 possibilities: empty, label, workload, ipaddress
-	for _, queryList := range queryLists {
-
-		// Labels
-		if len(queryList) > 0 {
-			if strings.Contains(queryList[0], "label") == true {
-				for _, label := range queryLists[i] {
-					queryLabel := Label{Href: label}
-					switch i {
-					case 0:
-						sourceInc = append(sourceInc, Include{Label: &queryLabel})
-					case 1:
-						destInc = append(destInc, Include{Label: &queryLabel})
-					case 2:
-						sourceExcl = append(sourceExcl, Exclude{Label: &queryLabel})
-					case 3:
-						destExcl = append(destExcl, Exclude{Label: &queryLabel})
-					}
-
-				}
-
-				// Workloads
-			} else if strings.Contains(queryList[0], "workload") == true {
-				for _, workload := range queryLists[i] {
-					queryWorkload := Workload{Href: workload}
-					switch i {
-					case 0:
-						sourceInc = append(sourceInc, Include{Workload: &queryWorkload})
-					case 1:
-						destInc = append(destInc, Include{Workload: &queryWorkload})
-					case 2:
-						sourceExcl = append(sourceExcl, Exclude{Workload: &queryWorkload})
-					case 3:
-						destExcl = append(destExcl, Exclude{Workload: &queryWorkload})
-					}
-
-				}
-
-				// Assume all else are IP addresses (API will error when needed)
-			} else if len(queryList[0]) > 0 {
-				for _, ipAddress := range queryLists[i] {
-					queryIPAddress := IPAddress{Value: ipAddress}
-					switch i {
-					case 0:
-						sourceInc = append(sourceInc, Include{IPAddress: &queryIPAddress})
-					case 1:
-						destInc = append(destInc, Include{IPAddress: &queryIPAddress})
-					case 2:
-						sourceExcl = append(sourceExcl, Exclude{IPAddress: &queryIPAddress})
-					case 3:
-						destExcl = append(destExcl, Exclude{IPAddress: &queryIPAddress})
-					}
-				}
-			}
-		}
+	// Build the request payload
+	traffic := buildTrafficAnalysisRequest(query)
 
-		i++
+	// Create JSON Payload
+	jsonPayload, err := json.Marshal(traffic)
+	if err != nil {
+		return nil, api, fmt.Errorf("get traffic analysis - %s", err)
+	}
+
+	var trafficResponses []TrafficAnalysis
+
+	// Build the API URL
+	apiURL, err := url.Parse("https://" + pceSanitization(pce.FQDN) + ":" + strconv.Itoa(pce.Port) + "/api/v1/orgs/" + strconv.Itoa(pce.Org) + "/traffic_flows/traffic_analysis_queries")
+	if err != nil {
+		return nil, api, fmt.Errorf("get traffic analysis - %s", err)
 	}
 
+	// Call the API
+	api, err = apicall("POST", apiURL.String(), pce, jsonPayload, false)
+	if err != nil {
+		return nil, api, fmt.Errorf("get traffic analysis - %s", err)
+	}
+
+	// Unmarshal response to struct
+	json.Unmarshal([]byte(api.RespBody), &trafficResponses)
+
+	return trafficResponses, api, nil
+
+}
+
+// buildTrafficAnalysisRequest converts a TrafficQuery into the payload object expected by the
+// traffic_analysis_queries endpoint.
+func buildTrafficAnalysisRequest(query TrafficQuery) TrafficAnalysisRequest {
+
+	// Initialize arrays using "make" so JSON is marshaled with empty arrays and not null values to meet Illumio API spec
+	sourceInc := query.SourcesInclude.includes()
+	destInc := query.DestinationsInclude.includes()
+	sourceExcl := query.SourcesExclude.excludes()
+	destExcl := query.DestinationsExclude.excludes()
+
 	// Get the service data ready
 	serviceInclude := make([]Include, 0)
 	serviceExclude := make([]Exclude, 0)
@@ -271,29 +291,117 @@ func GetTrafficAnalysis(pce PCE, query TrafficQuery) ([]TrafficAnalysis, APIResp
 		EndDate:         query.EndTime,
 		MaxResults:      query.MaxFLows}
 
+	return traffic
+}
+
+// GetTrafficAnalysisAsync gets flow data from Explorer using the PCE's async job API.
+// Unlike GetTrafficAnalysis, the response is decoded token-by-token with json.Decoder and streamed
+// over the returned channel rather than being buffered into memory as a single []TrafficAnalysis.
+// If query.MaxFLows is hit, the [StartTime,EndTime] window is bisected and each half is re-queried
+// so flows are never silently truncated. The error channel receives at most one error, sent before
+// either channel is closed; both channels are closed when the stream ends, so callers should range
+// over the result channel and then check the error channel (or select on both) to detect failures.
+func GetTrafficAnalysisAsync(pce PCE, query TrafficQuery) (<-chan TrafficAnalysis, <-chan error, error) {
+
+	resultChan := make(chan TrafficAnalysis)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(resultChan)
+		defer close(errChan)
+		if err := streamTrafficAnalysis(pce, query, resultChan); err != nil {
+			errChan <- err
+		}
+	}()
+
+	return resultChan, errChan, nil
+}
+
+// streamTrafficAnalysis issues a single async traffic_analysis_queries request, streams the decoded
+// records onto resultChan, and recurses on a bisected time window when the query's MaxFLows limit
+// was hit.
+func streamTrafficAnalysis(pce PCE, query TrafficQuery, resultChan chan<- TrafficAnalysis) error {
+
+	maxFlows := query.MaxFLows
+	if maxFlows == 0 {
+		maxFlows = defaultAsyncMaxFlows
+	}
+
+	// Build the request payload
+	traffic := buildTrafficAnalysisRequest(query)
+
 	// Create JSON Payload
 	jsonPayload, err := json.Marshal(traffic)
 	if err != nil {
-		return nil, api, fmt.Errorf("get traffic analysis - %s", err)
+		return fmt.Errorf("get traffic analysis async - %s", err)
 	}
 
-	var trafficResponses []TrafficAnalysis
-
 	// Build the API URL
 	apiURL, err := url.Parse("https://" + pceSanitization(pce.FQDN) + ":" + strconv.Itoa(pce.Port) + "/api/v1/orgs/" + strconv.Itoa(pce.Org) + "/traffic_flows/traffic_analysis_queries")
 	if err != nil {
-		return nil, api, fmt.Errorf("get traffic analysis - %s", err)
+		return fmt.Errorf("get traffic analysis async - %s", err)
 	}
 
-	// Call the API
-	api, err = apicall("POST", apiURL.String(), pce, jsonPayload, false)
+	// Call the API, flipping on Prefer: respond-async so apicall polls the job location for us.
+	// Use apicallStream so the decoder below reads directly off the live response body instead of
+	// a fully buffered string - that's what actually avoids holding the whole payload in memory.
+	resp, err := apicallStream("POST", apiURL.String(), pce, jsonPayload, true)
 	if err != nil {
-		return nil, api, fmt.Errorf("get traffic analysis - %s", err)
+		return fmt.Errorf("get traffic analysis async - %s", err)
 	}
+	defer resp.Body.Close()
 
-	// Unmarshal response to struct
-	json.Unmarshal([]byte(api.RespBody), &trafficResponses)
+	// Decode the response array token-by-token straight off resp.Body instead of a full
+	// ReadAll+Unmarshal
+	dec := json.NewDecoder(resp.Body)
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("get traffic analysis async - %s", err)
+	}
 
-	return trafficResponses, api, nil
+	var count int
+	for dec.More() {
+		var t TrafficAnalysis
+		if err := dec.Decode(&t); err != nil {
+			return fmt.Errorf("get traffic analysis async - %s", err)
+		}
+		resultChan <- t
+		count++
+	}
 
+	// Bisect and re-issue if we likely hit the MaxFLows ceiling. A query with no StartTime/EndTime
+	// bound (both are omitempty, so an unbounded window is a supported call) has nothing to bisect
+	// on, so report the truncation as an error instead of silently dropping everything past the
+	// cap - that silent drop is exactly the bug this function exists to avoid.
+	if count >= maxFlows {
+		if query.StartTime.IsZero() || query.EndTime.IsZero() {
+			return fmt.Errorf("get traffic analysis async - max flows (%d) reached and query has no start/end time to bisect", maxFlows)
+		}
+
+		leftWindow, rightWindow, ok := bisectTimeWindow(query.StartTime, query.EndTime)
+		if !ok {
+			return fmt.Errorf("get traffic analysis async - max flows (%d) reached and time window is too narrow to bisect further", maxFlows)
+		}
+
+		left, right := query, query
+		left.StartTime, left.EndTime = leftWindow[0], leftWindow[1]
+		right.StartTime, right.EndTime = rightWindow[0], rightWindow[1]
+		if err := streamTrafficAnalysis(pce, left, resultChan); err != nil {
+			return err
+		}
+		return streamTrafficAnalysis(pce, right, resultChan)
+	}
+
+	return nil
+}
+
+// bisectTimeWindow splits [start, end] into two non-overlapping sub-windows for re-querying after
+// MaxFLows is hit. The halves are [start,mid] and [mid+1ns,end], so a flow timestamped exactly at
+// the midpoint is returned only by the left half when the PCE's start/end filters are inclusive. ok is false if the window is too narrow to split into two non-empty halves.
+func bisectTimeWindow(start, end time.Time) (left, right [2]time.Time, ok bool) {
+	mid := start.Add(end.Sub(start) / 2)
+	rightStart := mid.Add(time.Nanosecond)
+	if !mid.After(start) || !rightStart.Before(end) {
+		return left, right, false
+	}
+	return [2]time.Time{start, mid}, [2]time.Time{rightStart, end}, true
 }
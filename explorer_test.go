@@ -0,0 +1,103 @@
+package illumioapi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildTrafficAnalysisRequest(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         TrafficQuery
+		wantSrcInc    int
+		wantDstInc    int
+		wantSvcInc    int
+		wantSvcExcl   int
+		wantPolicyLen int
+	}{
+		{
+			name:          "empty query produces empty (not nil) slices",
+			query:         TrafficQuery{},
+			wantSrcInc:    0,
+			wantDstInc:    0,
+			wantSvcInc:    0,
+			wantSvcExcl:   0,
+			wantPolicyLen: 0,
+		},
+		{
+			name: "selectors and service filters are all converted",
+			query: TrafficQuery{
+				SourcesInclude:        TrafficSelector{Labels: []Label{{Href: "/orgs/1/labels/1"}}, IPs: []string{"10.0.0.1"}},
+				DestinationsInclude:   TrafficSelector{Workloads: []Workload{{Href: "/orgs/1/workloads/1"}}},
+				PortProtoInclude:      [][2]int{{443, 6}},
+				PortRangeExclude:      [][2]int{{8000, 9000}},
+				ProcessInclude:        []string{"nginx"},
+				WindowsServiceExclude: []string{"svc"},
+				PolicyStatuses:        []string{"allowed", "potentially_blocked"},
+			},
+			wantSrcInc:    2,
+			wantDstInc:    1,
+			wantSvcInc:    2,
+			wantSvcExcl:   2,
+			wantPolicyLen: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buildTrafficAnalysisRequest(tt.query)
+
+			if got.Sources.Include == nil || got.Destinations.Include == nil {
+				t.Fatalf("Sources/Destinations.Include must never be nil (API requires an array, not null)")
+			}
+			if len(got.Sources.Include[0]) != tt.wantSrcInc {
+				t.Errorf("Sources.Include[0] length = %d, want %d", len(got.Sources.Include[0]), tt.wantSrcInc)
+			}
+			if len(got.Destinations.Include[0]) != tt.wantDstInc {
+				t.Errorf("Destinations.Include[0] length = %d, want %d", len(got.Destinations.Include[0]), tt.wantDstInc)
+			}
+			if len(got.ExplorerServices.Include) != tt.wantSvcInc {
+				t.Errorf("ExplorerServices.Include length = %d, want %d", len(got.ExplorerServices.Include), tt.wantSvcInc)
+			}
+			if len(got.ExplorerServices.Exclude) != tt.wantSvcExcl {
+				t.Errorf("ExplorerServices.Exclude length = %d, want %d", len(got.ExplorerServices.Exclude), tt.wantSvcExcl)
+			}
+			if len(got.PolicyDecisions) != tt.wantPolicyLen {
+				t.Errorf("PolicyDecisions length = %d, want %d", len(got.PolicyDecisions), tt.wantPolicyLen)
+			}
+		})
+	}
+}
+
+func TestBisectTimeWindow(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("splits into non-overlapping halves", func(t *testing.T) {
+		end := start.Add(time.Hour)
+
+		left, right, ok := bisectTimeWindow(start, end)
+		if !ok {
+			t.Fatalf("bisectTimeWindow(%v, %v) = ok false, want true", start, end)
+		}
+		if left[0] != start || right[1] != end {
+			t.Fatalf("halves don't cover the original window: left=%v right=%v", left, right)
+		}
+		if !left[1].Before(right[0]) {
+			t.Errorf("halves overlap at the boundary: left ends %v, right starts %v", left[1], right[0])
+		}
+	})
+
+	t.Run("too narrow a window cannot be split further", func(t *testing.T) {
+		end := start.Add(time.Nanosecond)
+
+		if _, _, ok := bisectTimeWindow(start, end); ok {
+			t.Errorf("bisectTimeWindow(%v, %v) = ok true, want false for a 1ns window", start, end)
+		}
+	})
+
+	t.Run("zero-length window cannot be split", func(t *testing.T) {
+		if _, _, ok := bisectTimeWindow(start, start); ok {
+			t.Errorf("bisectTimeWindow(%v, %v) = ok true, want false for a zero-length window", start, start)
+		}
+	})
+}
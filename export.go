@@ -0,0 +1,193 @@
+package illumioapi
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// trafficAnalysisRecord is the flattened, columnar form of a TrafficAnalysis record used by
+// ExportTrafficAnalysis and ExportTrafficAnalysisStream.
+type trafficAnalysisRecord struct {
+	SrcIP           string `json:"src_ip" parquet:"src_ip"`
+	SrcWorkloadHref string `json:"src_workload_href,omitempty" parquet:"src_workload_href,optional"`
+	SrcLabels       string `json:"src_labels,omitempty" parquet:"src_labels,optional"`
+	DstIP           string `json:"dst_ip" parquet:"dst_ip"`
+	DstWorkloadHref string `json:"dst_workload_href,omitempty" parquet:"dst_workload_href,optional"`
+	DstLabels       string `json:"dst_labels,omitempty" parquet:"dst_labels,optional"`
+	Port            int    `json:"port,omitempty" parquet:"port,optional"`
+	Proto           int    `json:"proto,omitempty" parquet:"proto,optional"`
+	Process         string `json:"process,omitempty" parquet:"process,optional"`
+	NumConnections  int    `json:"num_connections" parquet:"num_connections"`
+	PolicyDecision  string `json:"policy_decision" parquet:"policy_decision"`
+	FirstDetected   string `json:"first_detected" parquet:"first_detected"`
+	LastDetected    string `json:"last_detected" parquet:"last_detected"`
+}
+
+// trafficAnalysisColumns are the trafficAnalysisRecord fields in CSV column order.
+var trafficAnalysisColumns = []string{
+	"src_ip", "src_workload_href", "src_labels",
+	"dst_ip", "dst_workload_href", "dst_labels",
+	"port", "proto", "process",
+	"num_connections", "policy_decision",
+	"first_detected", "last_detected",
+}
+
+// flattenTrafficAnalysis flattens the nested Dst/Src/ExpSrv/TimestampRange structs of a
+// TrafficAnalysis into a trafficAnalysisRecord.
+func flattenTrafficAnalysis(flow TrafficAnalysis) trafficAnalysisRecord {
+	var record trafficAnalysisRecord
+
+	if flow.Src != nil {
+		record.SrcIP = flow.Src.IP
+		record.SrcWorkloadHref, record.SrcLabels = flattenWorkload(flow.Src.Workload)
+	}
+	if flow.Dst != nil {
+		record.DstIP = flow.Dst.IP
+		record.DstWorkloadHref, record.DstLabels = flattenWorkload(flow.Dst.Workload)
+	}
+	if flow.ExpSrv != nil {
+		record.Port = flow.ExpSrv.Port
+		record.Proto = flow.ExpSrv.Proto
+		record.Process = flow.ExpSrv.Process
+	}
+	if flow.TimestampRange != nil {
+		record.FirstDetected = flow.TimestampRange.FirstDetected
+		record.LastDetected = flow.TimestampRange.LastDetected
+	}
+	record.NumConnections = flow.NumConnections
+	record.PolicyDecision = flow.PolicyDecision
+
+	return record
+}
+
+// flattenWorkload returns a workload's href and its labels' hrefs joined with ";", for workloads
+// that carry labels.
+func flattenWorkload(workload *Workload) (href, labels string) {
+	if workload == nil {
+		return "", ""
+	}
+
+	hrefs := make([]string, 0, len(workload.Labels))
+	for _, label := range workload.Labels {
+		if label != nil {
+			hrefs = append(hrefs, label.Href)
+		}
+	}
+
+	return workload.Href, strings.Join(hrefs, ";")
+}
+
+// row returns the trafficAnalysisRecord as a CSV row matching trafficAnalysisColumns.
+func (r trafficAnalysisRecord) row() []string {
+	return []string{
+		r.SrcIP, r.SrcWorkloadHref, r.SrcLabels,
+		r.DstIP, r.DstWorkloadHref, r.DstLabels,
+		strconv.Itoa(r.Port), strconv.Itoa(r.Proto), r.Process,
+		strconv.Itoa(r.NumConnections), r.PolicyDecision,
+		r.FirstDetected, r.LastDetected,
+	}
+}
+
+// ExportTrafficAnalysis writes flows to w in the given format ("csv", "ndjson", or "parquet"),
+// flattening each record's nested Dst/Src/ExpSrv/TimestampRange structs into the columns described
+// by trafficAnalysisColumns.
+func ExportTrafficAnalysis(w io.Writer, format string, flows []TrafficAnalysis) error {
+	ch := make(chan TrafficAnalysis)
+
+	go func() {
+		defer close(ch)
+		for _, flow := range flows {
+			ch <- flow
+		}
+	}()
+
+	return ExportTrafficAnalysisStream(w, format, ch)
+}
+
+// ExportTrafficAnalysisStream writes flows read from ch to w in the given format as they arrive.
+// Pairing this with GetTrafficAnalysisAsync lets a multi-million-flow export run without ever
+// holding the full result set in memory.
+func ExportTrafficAnalysisStream(w io.Writer, format string, ch <-chan TrafficAnalysis) error {
+	switch format {
+	case "csv":
+		return exportTrafficAnalysisCSV(w, ch)
+	case "ndjson":
+		return exportTrafficAnalysisNDJSON(w, ch)
+	case "parquet":
+		return exportTrafficAnalysisParquet(w, ch)
+	default:
+		// Drain ch so a caller streaming from GetTrafficAnalysisAsync doesn't block forever
+		// sending to a reader that already gave up.
+		for range ch {
+		}
+		return fmt.Errorf("export traffic analysis - unsupported format %q", format)
+	}
+}
+
+func exportTrafficAnalysisCSV(w io.Writer, ch <-chan TrafficAnalysis) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write(trafficAnalysisColumns); err != nil {
+		// Drain ch so a caller streaming from GetTrafficAnalysisAsync doesn't block forever
+		// sending to a writer that already gave up.
+		for range ch {
+		}
+		return fmt.Errorf("export traffic analysis - %s", err)
+	}
+
+	for flow := range ch {
+		if err := cw.Write(flattenTrafficAnalysis(flow).row()); err != nil {
+			for range ch {
+			}
+			return fmt.Errorf("export traffic analysis - %s", err)
+		}
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("export traffic analysis - %s", err)
+	}
+	return nil
+}
+
+func exportTrafficAnalysisNDJSON(w io.Writer, ch <-chan TrafficAnalysis) error {
+	enc := json.NewEncoder(w)
+
+	for flow := range ch {
+		if err := enc.Encode(flattenTrafficAnalysis(flow)); err != nil {
+			// Drain ch so a caller streaming from GetTrafficAnalysisAsync doesn't block
+			// forever sending to a writer that already gave up.
+			for range ch {
+			}
+			return fmt.Errorf("export traffic analysis - %s", err)
+		}
+	}
+
+	return nil
+}
+
+// exportTrafficAnalysisParquet writes flows to w as a single-row-group parquet file using
+// trafficAnalysisRecord's parquet struct tags for the schema.
+func exportTrafficAnalysisParquet(w io.Writer, ch <-chan TrafficAnalysis) error {
+	pw := parquet.NewGenericWriter[trafficAnalysisRecord](w)
+
+	for flow := range ch {
+		if _, err := pw.Write([]trafficAnalysisRecord{flattenTrafficAnalysis(flow)}); err != nil {
+			for range ch {
+			}
+			pw.Close()
+			return fmt.Errorf("export traffic analysis - %s", err)
+		}
+	}
+
+	if err := pw.Close(); err != nil {
+		return fmt.Errorf("export traffic analysis - %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package illumioapi
+
+import "testing"
+
+func TestFlattenWorkload(t *testing.T) {
+	tests := []struct {
+		name       string
+		workload   *Workload
+		wantHref   string
+		wantLabels string
+	}{
+		{
+			name:       "nil workload flattens to empty strings",
+			workload:   nil,
+			wantHref:   "",
+			wantLabels: "",
+		},
+		{
+			name:       "workload with no labels",
+			workload:   &Workload{Href: "/orgs/1/workloads/1"},
+			wantHref:   "/orgs/1/workloads/1",
+			wantLabels: "",
+		},
+		{
+			name: "workload labels joined with semicolons, nil entries skipped",
+			workload: &Workload{
+				Href: "/orgs/1/workloads/1",
+				Labels: []*Label{
+					{Href: "/orgs/1/labels/1"},
+					nil,
+					{Href: "/orgs/1/labels/2"},
+				},
+			},
+			wantHref:   "/orgs/1/workloads/1",
+			wantLabels: "/orgs/1/labels/1;/orgs/1/labels/2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotHref, gotLabels := flattenWorkload(tt.workload)
+			if gotHref != tt.wantHref {
+				t.Errorf("href = %q, want %q", gotHref, tt.wantHref)
+			}
+			if gotLabels != tt.wantLabels {
+				t.Errorf("labels = %q, want %q", gotLabels, tt.wantLabels)
+			}
+		})
+	}
+}
+
+func TestFlattenTrafficAnalysis(t *testing.T) {
+	flow := TrafficAnalysis{
+		Src:            &Src{IP: "10.0.0.1", Workload: &Workload{Href: "/orgs/1/workloads/1"}},
+		Dst:            &Dst{IP: "10.0.0.2"},
+		ExpSrv:         &ExpSrv{Port: 443, Proto: 6, Process: "nginx"},
+		TimestampRange: &TimestampRange{FirstDetected: "2026-01-01T00:00:00Z", LastDetected: "2026-01-02T00:00:00Z"},
+		NumConnections: 5,
+		PolicyDecision: "allowed",
+	}
+
+	got := flattenTrafficAnalysis(flow)
+
+	want := trafficAnalysisRecord{
+		SrcIP:           "10.0.0.1",
+		SrcWorkloadHref: "/orgs/1/workloads/1",
+		DstIP:           "10.0.0.2",
+		Port:            443,
+		Proto:           6,
+		Process:         "nginx",
+		NumConnections:  5,
+		PolicyDecision:  "allowed",
+		FirstDetected:   "2026-01-01T00:00:00Z",
+		LastDetected:    "2026-01-02T00:00:00Z",
+	}
+
+	if got != want {
+		t.Errorf("flattenTrafficAnalysis() = %+v, want %+v", got, want)
+	}
+}
+
+func TestFlattenTrafficAnalysisNilNested(t *testing.T) {
+	got := flattenTrafficAnalysis(TrafficAnalysis{NumConnections: 1, PolicyDecision: "blocked"})
+
+	want := trafficAnalysisRecord{NumConnections: 1, PolicyDecision: "blocked"}
+	if got != want {
+		t.Errorf("flattenTrafficAnalysis() with all nested fields nil = %+v, want %+v", got, want)
+	}
+}
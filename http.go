@@ -2,15 +2,21 @@ package illumioapi
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"io"
 	"io/ioutil"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 	"time"
+
+	"golang.org/x/time/rate"
 )
 
 // APIResponse contains the information from the response of the API
@@ -29,6 +35,97 @@ type PCE struct {
 	User               string
 	Key                string
 	DisableTLSChecking bool
+
+	// HTTPClient, if set, is used for all requests instead of apicall constructing one per call.
+	HTTPClient *http.Client
+
+	// RetryPolicy configures retry/backoff/rate-limit behavior for apicall. A nil RetryPolicy
+	// falls back to defaultRetryPolicy.
+	RetryPolicy *RetryPolicy
+
+	// RateLimiter, if set, is waited on before every request made with this PCE (including async
+	// job polling and result fetches) so calls stay under a target rate. Build one with
+	// NewRateLimiter, normally from the same RetryPolicy, and share it across every call made with
+	// this PCE - a limiter only throttles correctly if it persists across calls, so nothing
+	// derives or rebuilds one automatically from RetryPolicy.RPS/Burst.
+	RateLimiter *rate.Limiter
+
+	// session holds the session-auth state set up by Login. apicall prefers it over User/Key
+	// whenever it is present and unexpired.
+	session *pceSession
+
+	// Observer, if set, is notified of every request/response/error apicall makes. See the
+	// metrics subpackage for a ready-made prometheus.Collector implementation.
+	Observer Observer
+}
+
+// RetryPolicy configures how apicall retries failed requests and rate limits outgoing requests.
+//
+// RPS and Burst only take effect if the caller also builds a *rate.Limiter with NewRateLimiter and
+// assigns it to PCE.RateLimiter - setting them here does not by itself throttle anything, and
+// changing them on an existing PCE has no effect until the limiter is rebuilt and reassigned.
+type RetryPolicy struct {
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	RPS        float64
+	Burst      int
+}
+
+// defaultRetryPolicy is used by apicall whenever a PCE has no RetryPolicy configured.
+var defaultRetryPolicy = RetryPolicy{MaxRetries: 3, MinBackoff: 500 * time.Millisecond, MaxBackoff: 10 * time.Second}
+
+// NewRateLimiter builds a token bucket limiter from a RetryPolicy's RPS/Burst settings. Assign the
+// result to PCE.RateLimiter so it is shared across every call made with that PCE.
+func NewRateLimiter(policy RetryPolicy) *rate.Limiter {
+	burst := policy.Burst
+	if burst <= 0 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(policy.RPS), burst)
+}
+
+// retryableStatusCode reports whether status is one apicall should retry after a backoff.
+func retryableStatusCode(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryableError reports whether err is a transient network error worth retrying.
+func retryableError(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// minBackoffFloor is the upper bound nextBackoff uses in place of MaxBackoff whenever a RetryPolicy
+// leaves MaxBackoff unset (MinBackoff is then capped at it too), so a caller who only sets
+// MaxRetries/RPS/Burst still gets a sane retry delay instead of a degenerate zero-length one.
+const minBackoffFloor = 100 * time.Millisecond
+
+// nextBackoff returns how long to wait before the next attempt, honoring a Retry-After header when
+// the PCE returned one and otherwise using exponential backoff with jitter bounded by policy.
+func nextBackoff(resp *http.Response, attempt int, policy RetryPolicy) time.Duration {
+	if resp != nil {
+		if wait, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			return time.Duration(wait) * time.Second
+		}
+	}
+
+	maxBackoff := policy.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = minBackoffFloor
+	}
+
+	backoff := policy.MinBackoff << uint(attempt)
+	if backoff <= 0 || backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	// Full jitter: sleep somewhere in [0, backoff)
+	return time.Duration(rand.Int63n(int64(backoff)))
 }
 
 // Unexported struct for handling the asyncResults
@@ -52,126 +149,262 @@ type asyncResults struct {
 // apiURL is the full endpoint being called.
 // PUT and POST methods should have a body that is JSON run through the json.marshal function so it's a []byte.
 // async parameter should be set to true for any GET requests returning > 500 items.
+// If pce.Observer is set, it is notified of the request, and of the eventual response or error.
 func apicall(httpAction, apiURL string, pce PCE, body []byte, async bool) (APIResponse, error) {
 
 	var response APIResponse
-	var httpBody *bytes.Buffer
+
+	resp, err := doAPICallObserved(httpAction, apiURL, pce, body, async)
+	if err != nil {
+		return response, err
+	}
+	defer resp.Body.Close()
+
+	// Process response
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return response, err
+	}
+
+	// Put relevant response info into struct
+	response.RespBody = string(data[:])
+	response.StatusCode = resp.StatusCode
+	response.Header = resp.Header
+	response.Request = resp.Request
+
+	// Check for a 200 response code
+	if strconv.Itoa(resp.StatusCode)[0:1] != "2" {
+		return response, errors.New("http status code of " + strconv.Itoa(response.StatusCode))
+	}
+
+	return response, nil
+}
+
+// apicallStream behaves like apicall but returns the live *http.Response instead of buffering its
+// body into an APIResponse, for callers (like GetTrafficAnalysisAsync) that decode the body as
+// bytes arrive instead of materializing the whole payload first. The caller must Close resp.Body
+// once it is done reading.
+func apicallStream(httpAction, apiURL string, pce PCE, body []byte, async bool) (*http.Response, error) {
+
+	resp, err := doAPICallObserved(httpAction, apiURL, pce, body, async)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check for a 200 response code
+	if strconv.Itoa(resp.StatusCode)[0:1] != "2" {
+		defer resp.Body.Close()
+		return nil, errors.New("http status code of " + strconv.Itoa(resp.StatusCode))
+	}
+
+	return resp, nil
+}
+
+// doAPICallObserved wraps doAPICall with the pce.Observer notifications shared by apicall and
+// apicallStream.
+func doAPICallObserved(httpAction, apiURL string, pce PCE, body []byte, async bool) (*http.Response, error) {
+
+	if pce.Observer != nil {
+		pce.Observer.OnRequest(httpAction, apiURL)
+	}
+
+	start := time.Now()
+	resp, retries, err := doAPICall(httpAction, apiURL, pce, body, async)
+
+	if pce.Observer != nil {
+		if err != nil {
+			pce.Observer.OnError(httpAction, apiURL, err)
+		} else {
+			pce.Observer.OnResponse(httpAction, apiURL, resp.StatusCode, time.Since(start), retries)
+		}
+	}
+
+	return resp, err
+}
+
+// doAPICall does the actual HTTP work (including retry and async-job polling) and returns the
+// final *http.Response with its body not yet read, plus how many retries the initial request and
+// (for async calls) the final result fetch took. Retries of the async job-status polls are not
+// counted.
+func doAPICall(httpAction, apiURL string, pce PCE, body []byte, async bool) (*http.Response, int, error) {
+
 	var asyncResults asyncResults
 
 	// Validate the provided action
 	httpAction = strings.ToUpper(httpAction)
 	if httpAction != "GET" && httpAction != "POST" && httpAction != "PUT" && httpAction != "DELETE" {
-		return response, errors.New("invalid http action string. action must be GET, POST, PUT, or DELETE")
+		return nil, 0, errors.New("invalid http action string. action must be GET, POST, PUT, or DELETE")
 	}
 
 	// Get the base URL
 	u, err := url.Parse(apiURL)
 	baseURL := "https://" + u.Host + "/api/v1"
 
-	// Create body
-	httpBody = bytes.NewBuffer(body)
-
-	// Create HTTP client and request
-	client := &http.Client{}
-	if pce.DisableTLSChecking == true {
-		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	// Create HTTP client
+	client := pce.HTTPClient
+	if client == nil {
+		client = &http.Client{}
+		if pce.DisableTLSChecking == true {
+			client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+		}
 	}
 
-	req, err := http.NewRequest(httpAction, apiURL, httpBody)
-	if err != nil {
-		return response, err
+	retryPolicy := defaultRetryPolicy
+	if pce.RetryPolicy != nil {
+		retryPolicy = *pce.RetryPolicy
 	}
 
-	// Set basic authentication and headers
-	req.SetBasicAuth(pce.User, pce.Key)
-	req.Header.Set("Content-Type", "application/json")
-	if async == true {
-		req.Header.Set("Prefer", "respond-async")
-	}
+	// Make the HTTP request, retrying on 429/502/503/504 and transient network timeouts with
+	// exponential backoff, and throttling through pce.RateLimiter if one is set. The same
+	// request/retry/rate-limit machinery backs the async job-status poll and final result fetch
+	// below, so a transient failure on any of the three doesn't abort the whole call.
+	resp, attempt, err := doRequestWithRetry(client, pce.RateLimiter, retryPolicy, func() (*http.Request, error) {
+		req, err := http.NewRequest(httpAction, apiURL, bytes.NewBuffer(body))
+		if err != nil {
+			return nil, err
+		}
 
-	// Make HTTP Request
-	resp, err := client.Do(req)
+		// Set authentication and headers, preferring a live session token over basic auth
+		if user, token, ok := pce.sessionAuth(); ok {
+			req.SetBasicAuth(user, token)
+		} else {
+			req.SetBasicAuth(pce.User, pce.Key)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if async == true {
+			req.Header.Set("Prefer", "respond-async")
+		}
+		return req, nil
+	})
 	if err != nil {
-		return response, err
+		return nil, attempt, err
 	}
 
 	// Process Async requests
 	if async == true {
 		for asyncResults.Status != "done" {
-			asyncResults, err = polling(baseURL, pce, resp)
+			asyncResults, err = polling(baseURL, pce, client, retryPolicy, resp)
 			if err != nil {
-				return response, err
+				resp.Body.Close()
+				return nil, attempt, err
 			}
 		}
+		// The initial accepted-async response body is unread at this point - close it before
+		// resp is reassigned to the job result below.
+		resp.Body.Close()
+
+		finalResp, finalAttempt, err := doRequestWithRetry(client, pce.RateLimiter, retryPolicy, func() (*http.Request, error) {
+			finalReq, err := http.NewRequest("GET", baseURL+asyncResults.Result.Href, nil)
+			if err != nil {
+				return nil, err
+			}
 
-		finalReq, err := http.NewRequest("GET", baseURL+asyncResults.Result.Href, httpBody)
+			// Set authentication and headers, preferring a live session token over basic auth
+			if user, token, ok := pce.sessionAuth(); ok {
+				finalReq.SetBasicAuth(user, token)
+			} else {
+				finalReq.SetBasicAuth(pce.User, pce.Key)
+			}
+			finalReq.Header.Set("Content-Type", "application/json")
+			return finalReq, nil
+		})
+		attempt += finalAttempt
 		if err != nil {
-			return response, err
+			return nil, attempt, err
 		}
+		resp = finalResp
+	}
 
-		// Set basic authentication and headers
-		finalReq.SetBasicAuth(pce.User, pce.Key)
-		finalReq.Header.Set("Content-Type", "application/json")
+	return resp, attempt, nil
+}
+
+// requestBuilder builds a single HTTP request for one attempt of doRequestWithRetry. A new request
+// is built on every attempt since a request's body can only be read once.
+type requestBuilder func() (*http.Request, error)
+
+// doRequestWithRetry sends the request built by build, retrying on a retryable status code or
+// transient network error with exponential backoff, and waiting on limiter (if set) before every
+// attempt including the first. It returns the final response together with how many retries it
+// took to get there.
+func doRequestWithRetry(client *http.Client, limiter *rate.Limiter, retryPolicy RetryPolicy, build requestBuilder) (*http.Response, int, error) {
+	var resp *http.Response
+	var attempt int
+
+	for ; ; attempt++ {
+		if limiter != nil {
+			if err := limiter.Wait(context.Background()); err != nil {
+				return nil, attempt, err
+			}
+		}
 
-		// Make HTTP Request
-		resp, err = client.Do(finalReq)
+		req, err := build()
 		if err != nil {
-			return response, err
+			return nil, attempt, err
 		}
-	}
 
-	// Process response
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return response, err
-	}
+		resp, err = client.Do(req)
+		if err == nil && !retryableStatusCode(resp.StatusCode) {
+			return resp, attempt, nil
+		}
+		if err != nil && !retryableError(err) {
+			return nil, attempt, err
+		}
+		if attempt >= retryPolicy.MaxRetries {
+			if err != nil {
+				return nil, attempt, err
+			}
+			return resp, attempt, nil
+		}
 
-	// Put relevant response info into struct
-	response.RespBody = string(data[:])
-	response.StatusCode = resp.StatusCode
-	response.Header = resp.Header
-	response.Request = resp.Request
+		// Drain and close the failed attempt's body so its connection is returned to the pool
+		// instead of leaking before the retry opens a new one. resp is nil here when client.Do
+		// itself returned a retryable network error rather than a retryable status code.
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
 
-	// Check for a 200 response code
-	if strconv.Itoa(resp.StatusCode)[0:1] != "2" {
-		return response, errors.New("http status code of " + strconv.Itoa(response.StatusCode))
+		time.Sleep(nextBackoff(resp, attempt, retryPolicy))
 	}
-
-	// Return data and nil error
-	return response, nil
 }
 
-func polling(baseURL string, pce PCE, origResp *http.Response) (asyncResults, error) {
+// polling issues a single async job-status poll (retried like any other request) and reports the
+// decoded asyncResults.
+func polling(baseURL string, pce PCE, client *http.Client, retryPolicy RetryPolicy, origResp *http.Response) (asyncResults, error) {
 
 	var asyncResults asyncResults
 
-	// Create HTTP client and request
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-	client := &http.Client{Transport: tr}
-	pollReq, err := http.NewRequest("GET", baseURL+origResp.Header.Get("Location"), nil)
-	if err != nil {
-		return asyncResults, err
-	}
-
-	// Set basic authentication and headers
-	pollReq.SetBasicAuth(pce.User, pce.Key)
-	pollReq.Header.Set("Content-Type", "application/json")
-
 	// Wait for recommended time from Retry-After
 	wait, err := strconv.Atoi(origResp.Header.Get("Retry-After"))
 	if err != nil {
 		return asyncResults, err
 	}
-	duration := time.Duration(wait) * time.Second
-	time.Sleep(duration)
+	time.Sleep(time.Duration(wait) * time.Second)
+
+	pollURL := baseURL + origResp.Header.Get("Location")
+	pollResp, _, err := doRequestWithRetry(client, pce.RateLimiter, retryPolicy, func() (*http.Request, error) {
+		pollReq, err := http.NewRequest("GET", pollURL, nil)
+		if err != nil {
+			return nil, err
+		}
 
-	// Check if the data is ready
-	pollResp, err := client.Do(pollReq)
+		// Set authentication and headers, preferring a live session token over basic auth
+		if user, token, ok := pce.sessionAuth(); ok {
+			pollReq.SetBasicAuth(user, token)
+		} else {
+			pollReq.SetBasicAuth(pce.User, pce.Key)
+		}
+		pollReq.Header.Set("Content-Type", "application/json")
+
+		if pollObserver, ok := pce.Observer.(AsyncPollObserver); ok {
+			pollObserver.OnAsyncPollAttempt("GET", pollURL)
+		}
+		return pollReq, nil
+	})
 	if err != nil {
 		return asyncResults, err
 	}
+	defer pollResp.Body.Close()
 
 	// Process Response
 	data, err := ioutil.ReadAll(pollResp.Body)
@@ -182,7 +415,7 @@ func polling(baseURL string, pce PCE, origResp *http.Response) (asyncResults, er
 	// Put relevant response info into struct
 	json.Unmarshal(data[:], &asyncResults)
 
-	return asyncResults, err
+	return asyncResults, nil
 }
 
 // pceSanitization cleans up the provided PCE FQDN in case of common errors
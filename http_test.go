@@ -0,0 +1,51 @@
+package illumioapi
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNextBackoffHonorsRetryAfter(t *testing.T) {
+	resp := &http.Response{Header: http.Header{"Retry-After": []string{"2"}}}
+
+	got := nextBackoff(resp, 0, defaultRetryPolicy)
+	if got != 2*time.Second {
+		t.Errorf("nextBackoff() = %v, want 2s", got)
+	}
+}
+
+func TestNextBackoffNeverPanicsOnDegeneratePolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+	}{
+		{"zero-value policy", RetryPolicy{}},
+		{"MaxRetries set but no backoff bounds", RetryPolicy{MaxRetries: 3, RPS: 5, Burst: 10}},
+		{"MinBackoff set, MaxBackoff left zero", RetryPolicy{MinBackoff: time.Second}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("nextBackoff panicked: %v", r)
+				}
+			}()
+			if got := nextBackoff(nil, 0, tt.policy); got < 0 {
+				t.Errorf("nextBackoff() = %v, want >= 0", got)
+			}
+		})
+	}
+}
+
+func TestNextBackoffBoundedByMaxBackoff(t *testing.T) {
+	policy := RetryPolicy{MinBackoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	for attempt := 0; attempt < 10; attempt++ {
+		got := nextBackoff(nil, attempt, policy)
+		if got < 0 || got > policy.MaxBackoff {
+			t.Errorf("nextBackoff(attempt=%d) = %v, want within [0, %v]", attempt, got, policy.MaxBackoff)
+		}
+	}
+}
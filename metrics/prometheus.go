@@ -0,0 +1,100 @@
+// Package metrics provides optional Observer implementations for illumioapi.PCE.Observer that
+// depend on third-party metrics libraries. It is a separate package from illumioapi so that
+// importing illumioapi doesn't pull in those dependencies for callers who don't use them.
+package metrics
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrometheusObserver is an illumioapi.Observer and a prometheus.Collector that exposes
+// illumio_api_requests_total, illumio_api_request_duration_seconds, and
+// illumio_api_async_poll_attempts. Register it with a prometheus.Registerer and assign it to
+// PCE.Observer.
+type PrometheusObserver struct {
+	requestsTotal     *prometheus.CounterVec
+	requestDuration   *prometheus.HistogramVec
+	asyncPollAttempts prometheus.Counter
+}
+
+// NewPrometheusObserver builds a PrometheusObserver with freshly created metrics.
+func NewPrometheusObserver() *PrometheusObserver {
+	return &PrometheusObserver{
+		requestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "illumio_api_requests_total",
+			Help: "Total number of requests made to the Illumio PCE API, by endpoint, method, and status code.",
+		}, []string{"endpoint", "method", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "illumio_api_request_duration_seconds",
+			Help: "Latency of requests made to the Illumio PCE API, by endpoint and method.",
+		}, []string{"endpoint", "method"}),
+		asyncPollAttempts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "illumio_api_async_poll_attempts",
+			Help: "Total number of async job status polls issued while waiting for a PCE job to complete.",
+		}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (o *PrometheusObserver) Describe(ch chan<- *prometheus.Desc) {
+	o.requestsTotal.Describe(ch)
+	o.requestDuration.Describe(ch)
+	o.asyncPollAttempts.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (o *PrometheusObserver) Collect(ch chan<- prometheus.Metric) {
+	o.requestsTotal.Collect(ch)
+	o.requestDuration.Collect(ch)
+	o.asyncPollAttempts.Collect(ch)
+}
+
+// OnRequest implements illumioapi.Observer.
+func (o *PrometheusObserver) OnRequest(method, url string) {}
+
+// OnResponse implements illumioapi.Observer.
+func (o *PrometheusObserver) OnResponse(method, url string, status int, latency time.Duration, retries int) {
+	o.requestsTotal.WithLabelValues(endpointLabel(url), method, strconv.Itoa(status)).Inc()
+	o.requestDuration.WithLabelValues(endpointLabel(url), method).Observe(latency.Seconds())
+}
+
+// OnError implements illumioapi.Observer.
+func (o *PrometheusObserver) OnError(method, url string, err error) {
+	o.requestsTotal.WithLabelValues(endpointLabel(url), method, "error").Inc()
+}
+
+// OnAsyncPollAttempt implements illumioapi.AsyncPollObserver.
+func (o *PrometheusObserver) OnAsyncPollAttempt(method, url string) {
+	o.asyncPollAttempts.Inc()
+}
+
+// idSegment matches a path segment that identifies a specific resource (a UUID, or a purely
+// numeric ID) rather than naming a route, so endpointLabel can template it out.
+var idSegment = regexp.MustCompile(`(?i)^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$|^[0-9]+$`)
+
+// endpointLabel reduces a full request URL down to its path, templating out resource IDs, for use
+// as a low-cardinality metric label, e.g.
+// "https://pce.local:8443/api/v1/orgs/1/pairing_profiles/3b1f.../pairing_key?name=foo" ->
+// "/api/v1/orgs/:id/pairing_profiles/:id/pairing_key". Without this, a route that embeds a
+// resource ID in its path (every href-based call) would mint an unbounded number of time series,
+// one per resource ever touched.
+func endpointLabel(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	segments := strings.Split(u.Path, "/")
+	for i, segment := range segments {
+		if idSegment.MatchString(segment) {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
+}
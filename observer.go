@@ -0,0 +1,18 @@
+package illumioapi
+
+import "time"
+
+// Observer lets callers hook into apicall for logging or metrics without modifying this module. A
+// nil PCE.Observer disables all hooks.
+type Observer interface {
+	OnRequest(method, url string)
+	OnResponse(method, url string, status int, latency time.Duration, retries int)
+	OnError(method, url string, err error)
+}
+
+// AsyncPollObserver is an Observer that also wants to know about async job status polls. It is
+// kept separate from Observer so existing Observer implementations don't need updating.
+type AsyncPollObserver interface {
+	Observer
+	OnAsyncPollAttempt(method, url string)
+}
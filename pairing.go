@@ -6,6 +6,43 @@ import (
 	"strconv"
 )
 
+// PairingProfileFilter filters the pairing profiles returned by GetAllPairingProfilesFilter.
+// Zero-value fields are left off the request entirely rather than sent as empty filters.
+type PairingProfileFilter struct {
+	Name            string
+	Enabled         *bool
+	Labels          []Label
+	ExternalDataSet string
+}
+
+// queryValues URL-encodes the filter into the query params documented for GET pairing_profiles.
+func (f PairingProfileFilter) queryValues() (url.Values, error) {
+	values := url.Values{}
+
+	if f.Name != "" {
+		values.Set("name", f.Name)
+	}
+	if f.Enabled != nil {
+		values.Set("enabled", strconv.FormatBool(*f.Enabled))
+	}
+	if f.ExternalDataSet != "" {
+		values.Set("external_data_set", f.ExternalDataSet)
+	}
+	if len(f.Labels) > 0 {
+		hrefs := make([]string, len(f.Labels))
+		for i, label := range f.Labels {
+			hrefs[i] = label.Href
+		}
+		labelsJSON, err := json.Marshal(hrefs)
+		if err != nil {
+			return nil, err
+		}
+		values.Set("labels", string(labelsJSON))
+	}
+
+	return values, nil
+}
+
 // PairingProfile represents a pairing profile in the Illumio PCE
 type PairingProfile struct {
 	AllowedUsesPerKey     string     `json:"allowed_uses_per_key,omitempty"`
@@ -65,6 +102,36 @@ func GetAllPairingProfiles(pce PCE) ([]PairingProfile, APIResponse, error) {
 	return pairingProfiles, api, nil
 }
 
+// GetAllPairingProfilesFilter gets all pairing profiles in the Illumio PCE matching filter.
+func GetAllPairingProfilesFilter(pce PCE, filter PairingProfileFilter) ([]PairingProfile, APIResponse, error) {
+	var pairingProfiles []PairingProfile
+	var api APIResponse
+
+	// Build the API URL
+	apiURL, err := url.Parse("https://" + pceSanitization(pce.FQDN) + ":" + strconv.Itoa(pce.Port) + "/api/v1/orgs/" + strconv.Itoa(pce.Org) + "/pairing_profiles")
+	if err != nil {
+		return pairingProfiles, api, err
+	}
+
+	// Apply the filter as query params
+	values, err := filter.queryValues()
+	if err != nil {
+		return pairingProfiles, api, err
+	}
+	apiURL.RawQuery = values.Encode()
+
+	// Call the API
+	api, err = apicall("GET", apiURL.String(), pce, nil, false)
+	if err != nil {
+		return pairingProfiles, api, err
+	}
+
+	// Unmarshal response to struct
+	json.Unmarshal([]byte(api.RespBody), &pairingProfiles)
+
+	return pairingProfiles, api, nil
+}
+
 // CreatePairingProfile creates a new pairing profile in the Illumio PCE.
 func CreatePairingProfile(pce PCE, pairingProfile PairingProfile) (APIResponse, error) {
 	var api APIResponse
@@ -0,0 +1,56 @@
+package illumioapi
+
+import "testing"
+
+func TestPairingProfileFilterQueryValues(t *testing.T) {
+	boolTrue := true
+
+	tests := []struct {
+		name   string
+		filter PairingProfileFilter
+		want   string
+	}{
+		{
+			name:   "zero-value filter sends no params",
+			filter: PairingProfileFilter{},
+			want:   "",
+		},
+		{
+			name:   "name only",
+			filter: PairingProfileFilter{Name: "default"},
+			want:   "name=default",
+		},
+		{
+			name:   "enabled is sent when explicitly set, even though false is the zero value",
+			filter: PairingProfileFilter{Enabled: new(bool)},
+			want:   "enabled=false",
+		},
+		{
+			name:   "enabled true",
+			filter: PairingProfileFilter{Enabled: &boolTrue},
+			want:   "enabled=true",
+		},
+		{
+			name:   "labels are JSON-encoded hrefs",
+			filter: PairingProfileFilter{Labels: []Label{{Href: "/orgs/1/labels/1"}, {Href: "/orgs/1/labels/2"}}},
+			want:   `labels=%5B%22%2Forgs%2F1%2Flabels%2F1%22%2C%22%2Forgs%2F1%2Flabels%2F2%22%5D`,
+		},
+		{
+			name:   "external data set",
+			filter: PairingProfileFilter{ExternalDataSet: "puppet"},
+			want:   "external_data_set=puppet",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := tt.filter.queryValues()
+			if err != nil {
+				t.Fatalf("queryValues() returned error: %s", err)
+			}
+			if got := values.Encode(); got != tt.want {
+				t.Errorf("queryValues().Encode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package illumioapi
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sessionRenewBuffer is how far ahead of a session token's expiration the renewer logs back in, so
+// in-flight requests never race an expiring token.
+const sessionRenewBuffer = 30 * time.Second
+
+// sessionRenewMinBackoff and sessionRenewMaxBackoff bound the retry delay renewSession uses after a
+// failed login, so a transient PCE/network hiccup doesn't permanently disable renewal.
+const (
+	sessionRenewMinBackoff = 1 * time.Second
+	sessionRenewMaxBackoff = 1 * time.Minute
+)
+
+// pceSession holds the session-auth state for a PCE. It is read by apicall on every request and
+// written by the background renewal goroutine started by Login, so all access goes through mu.
+type pceSession struct {
+	mu           sync.RWMutex
+	authUsername string
+	token        string
+	expiresAt    time.Time
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+// loginResponse is the payload returned by POST /api/v2/users/login.
+type loginResponse struct {
+	AuthUsername string `json:"auth_username"`
+	Token        string `json:"token"`
+}
+
+// sessionResponse is the payload returned by POST /api/v2/sessions.
+type sessionResponse struct {
+	SessionToken string `json:"session_token"`
+	ExpiresAt    string `json:"expires_at"`
+}
+
+// Login authenticates to the PCE with a username and password, exchanging them for a session
+// token via /api/v2/users/login and /api/v2/sessions. Once logged in, apicall transparently uses
+// the session token instead of the PCE's static User/Key basic auth, and a background goroutine
+// renews the session before it expires, falling back to the static API key if renewal fails. Call
+// Close to stop the renewer.
+func (pce *PCE) Login(username, password string) error {
+
+	lr, sr, err := pceLogin(*pce, username, password)
+	if err != nil {
+		return err
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, sr.ExpiresAt)
+	if err != nil {
+		expiresAt = time.Now().Add(10 * time.Minute)
+	}
+
+	pce.session = &pceSession{
+		authUsername: lr.AuthUsername,
+		token:        sr.SessionToken,
+		expiresAt:    expiresAt,
+		stopCh:       make(chan struct{}),
+	}
+
+	go renewSession(pce.session, *pce, username, password)
+
+	return nil
+}
+
+// Close stops the background session renewal goroutine started by Login. It is a no-op if Login
+// was never called.
+func (pce *PCE) Close() {
+	if pce.session == nil {
+		return
+	}
+	pce.session.stopOnce.Do(func() {
+		close(pce.session.stopCh)
+	})
+}
+
+// renewSession renews session before it expires until stopped via session.stopCh. A failed renewal
+// is treated as transient: sessionAuth naturally falls back to the PCE's static API key for any
+// request that arrives before the next successful login (mirroring a RenewBehaviorIgnoreErrors-style
+// fallback), and renewSession retries the login with exponential backoff instead of giving up.
+func renewSession(session *pceSession, pce PCE, username, password string) {
+	backoff := sessionRenewMinBackoff
+	for {
+		session.mu.RLock()
+		wait := time.Until(session.expiresAt) - sessionRenewBuffer
+		session.mu.RUnlock()
+		if wait < 0 {
+			wait = 0
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-session.stopCh:
+			return
+		}
+
+		lr, sr, err := pceLogin(pce, username, password)
+		if err != nil {
+			select {
+			case <-time.After(backoff):
+			case <-session.stopCh:
+				return
+			}
+			backoff = nextSessionRenewBackoff(backoff)
+			continue
+		}
+		backoff = sessionRenewMinBackoff
+
+		expiresAt, err := time.Parse(time.RFC3339, sr.ExpiresAt)
+		if err != nil {
+			expiresAt = time.Now().Add(10 * time.Minute)
+		}
+
+		session.mu.Lock()
+		session.authUsername = lr.AuthUsername
+		session.token = sr.SessionToken
+		session.expiresAt = expiresAt
+		session.mu.Unlock()
+	}
+}
+
+// nextSessionRenewBackoff doubles backoff for the next failed-login retry, capped at
+// sessionRenewMaxBackoff.
+func nextSessionRenewBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > sessionRenewMaxBackoff {
+		backoff = sessionRenewMaxBackoff
+	}
+	return backoff
+}
+
+// pceLogin performs the login + session exchange and returns the decoded responses.
+func pceLogin(pce PCE, username, password string) (loginResponse, sessionResponse, error) {
+
+	var lr loginResponse
+	var sr sessionResponse
+
+	client := pce.HTTPClient
+	if client == nil {
+		client = &http.Client{}
+		if pce.DisableTLSChecking == true {
+			client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+		}
+	}
+
+	baseURL := "https://" + pceSanitization(pce.FQDN) + ":" + strconv.Itoa(pce.Port) + "/api/v2"
+
+	loginBody, err := json.Marshal(map[string]string{"username": username, "password": password})
+	if err != nil {
+		return lr, sr, fmt.Errorf("login - %s", err)
+	}
+	if err := postJSON(client, baseURL+"/users/login", loginBody, &lr); err != nil {
+		return lr, sr, fmt.Errorf("login - %s", err)
+	}
+
+	sessionBody, err := json.Marshal(map[string]string{"auth_username": lr.AuthUsername, "token": lr.Token})
+	if err != nil {
+		return lr, sr, fmt.Errorf("login - %s", err)
+	}
+	if err := postJSON(client, baseURL+"/sessions", sessionBody, &sr); err != nil {
+		return lr, sr, fmt.Errorf("login - %s", err)
+	}
+
+	return lr, sr, nil
+}
+
+// postJSON POSTs body to url and decodes a 2xx JSON response into out.
+func postJSON(client *http.Client, url string, body []byte, out interface{}) error {
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if strconv.Itoa(resp.StatusCode)[0:1] != "2" {
+		return fmt.Errorf("http status code of %d", resp.StatusCode)
+	}
+
+	return json.Unmarshal(data, out)
+}
+
+// sessionAuth returns the PCE's current session credentials and whether they are present and not
+// yet expired. apicall falls back to basic auth with pce.User/Key when ok is false.
+func (pce PCE) sessionAuth() (username, token string, ok bool) {
+	if pce.session == nil {
+		return "", "", false
+	}
+
+	pce.session.mu.RLock()
+	defer pce.session.mu.RUnlock()
+
+	if pce.session.token == "" || time.Now().After(pce.session.expiresAt) {
+		return "", "", false
+	}
+	return pce.session.authUsername, pce.session.token, true
+}
@@ -0,0 +1,62 @@
+package illumioapi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextSessionRenewBackoffDoublesAndCaps(t *testing.T) {
+	backoff := sessionRenewMinBackoff
+	for i := 0; i < 10; i++ {
+		backoff = nextSessionRenewBackoff(backoff)
+		if backoff > sessionRenewMaxBackoff {
+			t.Fatalf("backoff = %v, want <= sessionRenewMaxBackoff (%v)", backoff, sessionRenewMaxBackoff)
+		}
+	}
+	if backoff != sessionRenewMaxBackoff {
+		t.Errorf("backoff after repeated failures = %v, want it to have settled at the cap %v", backoff, sessionRenewMaxBackoff)
+	}
+}
+
+func TestSessionAuth(t *testing.T) {
+	tests := []struct {
+		name    string
+		session *pceSession
+		wantOK  bool
+	}{
+		{
+			name:    "no session configured falls back to basic auth",
+			session: nil,
+			wantOK:  false,
+		},
+		{
+			name:    "empty token falls back to basic auth",
+			session: &pceSession{token: "", expiresAt: time.Now().Add(time.Hour)},
+			wantOK:  false,
+		},
+		{
+			name:    "expired token falls back to basic auth",
+			session: &pceSession{token: "tok", expiresAt: time.Now().Add(-time.Minute)},
+			wantOK:  false,
+		},
+		{
+			name:    "unexpired token is used",
+			session: &pceSession{authUsername: "user", token: "tok", expiresAt: time.Now().Add(time.Hour)},
+			wantOK:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pce := PCE{session: tt.session}
+
+			username, token, ok := pce.sessionAuth()
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && (username != tt.session.authUsername || token != tt.session.token) {
+				t.Errorf("sessionAuth() = (%q, %q), want (%q, %q)", username, token, tt.session.authUsername, tt.session.token)
+			}
+		})
+	}
+}